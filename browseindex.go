@@ -0,0 +1,290 @@
+//
+// browseindex.go - a persistent, incrementally maintained browse index for
+// accession records, backed by BoltDB.
+//
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package cait
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// BrowseIndexFilename is the BoltDB file MakeAccessionTitleIndex
+// maintains inside a dataset directory to incrementally track the
+// browse index across runs.
+const BrowseIndexFilename = ".cait-browse.db"
+
+const (
+	byURIBucket   = "accessions-by-uri"
+	byTitleBucket = "accessions-by-title"
+)
+
+// BrowseIndex is a persistent, incrementally maintained browse index for
+// accession records. It replaces rebuilding the full title index from
+// scratch on every run: AddOrUpdate only touches a record when its mtime
+// is newer than what's stored, so re-indexing after a partial harvest is
+// O(changed) rather than O(all).
+type BrowseIndex struct {
+	db *bolt.DB
+}
+
+// indexEntry is the value stored in byURIBucket, enough to locate (and
+// remove) an entry's position in byTitleBucket and to compare mtimes.
+type indexEntry struct {
+	SortKey string `json:"sort_key"`
+	Title   string `json:"title"`
+	URI     string `json:"uri"`
+	MTime   int64  `json:"mtime"`
+}
+
+// OpenBrowseIndex opens (creating if necessary) a BoltDB backed browse
+// index at path.
+func OpenBrowseIndex(path string) (*BrowseIndex, error) {
+	db, err := bolt.Open(path, 0664, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Can't open browse index %s, %s", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(byURIBucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte(byTitleBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Can't initialize browse index %s, %s", path, err)
+	}
+	return &BrowseIndex{db: db}, nil
+}
+
+// Close releases the underlying BoltDB file handle.
+func (idx *BrowseIndex) Close() error {
+	return idx.db.Close()
+}
+
+// normalizeSortTitle folds case and collapses whitespace so titles sort
+// the way a human browsing alphabetically would expect.
+func normalizeSortTitle(title string) string {
+	return strings.Join(strings.Fields(strings.ToLower(title)), " ")
+}
+
+// sortKey is the byTitleBucket key for title/uri. The uri suffix keeps
+// keys unique (and their order stable) when two records share a title.
+func sortKey(title, uri string) string {
+	return normalizeSortTitle(title) + "\x00" + uri
+}
+
+// AddOrUpdate records (or updates) the browse entry for uri. If the
+// index already has an entry for uri whose mtime is at least as new as
+// mtime, the call is a no-op, so a full re-walk of a dataset only pays
+// for the files that actually changed.
+func (idx *BrowseIndex) AddOrUpdate(uri, title string, mtime time.Time) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		byURI := tx.Bucket([]byte(byURIBucket))
+		byTitle := tx.Bucket([]byte(byTitleBucket))
+
+		if raw := byURI.Get([]byte(uri)); raw != nil {
+			prev := new(indexEntry)
+			if err := json.Unmarshal(raw, prev); err == nil {
+				if !mtime.After(time.Unix(prev.MTime, 0)) {
+					return nil
+				}
+				if err := byTitle.Delete([]byte(prev.SortKey)); err != nil {
+					return err
+				}
+			}
+		}
+
+		entry := &indexEntry{
+			SortKey: sortKey(title, uri),
+			Title:   title,
+			URI:     uri,
+			MTime:   mtime.Unix(),
+		}
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := byURI.Put([]byte(uri), raw); err != nil {
+			return err
+		}
+		return byTitle.Put([]byte(entry.SortKey), []byte(uri))
+	})
+}
+
+// Remove deletes uri from the browse index.
+func (idx *BrowseIndex) Remove(uri string) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		byURI := tx.Bucket([]byte(byURIBucket))
+		byTitle := tx.Bucket([]byte(byTitleBucket))
+		raw := byURI.Get([]byte(uri))
+		if raw == nil {
+			return nil
+		}
+		entry := new(indexEntry)
+		if err := json.Unmarshal(raw, entry); err == nil {
+			if err := byTitle.Delete([]byte(entry.SortKey)); err != nil {
+				return err
+			}
+		}
+		return byURI.Delete([]byte(uri))
+	})
+}
+
+// navFromURI looks up uri in byURIBucket and returns a NavElementView
+// carrying just that record's own label/uri (no Prev/Next filled in).
+func navFromURI(byURI *bolt.Bucket, uri string) *NavElementView {
+	raw := byURI.Get([]byte(uri))
+	if raw == nil {
+		return nil
+	}
+	entry := new(indexEntry)
+	if err := json.Unmarshal(raw, entry); err != nil {
+		return nil
+	}
+	return &NavElementView{ThisLabel: entry.Title, ThisURI: entry.URI}
+}
+
+// Neighbors returns the browse entries immediately before and after uri
+// in sorted title order. Either may be nil if uri is the first or last
+// entry. It returns an error if uri isn't indexed at all.
+func (idx *BrowseIndex) Neighbors(uri string) (prev *NavElementView, next *NavElementView, err error) {
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		byURI := tx.Bucket([]byte(byURIBucket))
+		byTitle := tx.Bucket([]byte(byTitleBucket))
+		raw := byURI.Get([]byte(uri))
+		if raw == nil {
+			return fmt.Errorf("%s is not in the browse index", uri)
+		}
+		entry := new(indexEntry)
+		if err := json.Unmarshal(raw, entry); err != nil {
+			return err
+		}
+
+		c := byTitle.Cursor()
+		c.Seek([]byte(entry.SortKey))
+		if pk, pv := c.Prev(); pk != nil {
+			prev = navFromURI(byURI, string(pv))
+		}
+		c.Seek([]byte(entry.SortKey))
+		if nk, nv := c.Next(); nk != nil {
+			next = navFromURI(byURI, string(nv))
+		}
+		return nil
+	})
+	return prev, next, err
+}
+
+// Range returns up to limit browse entries with normalized titles between
+// fromTitle and toTitle inclusive. An empty toTitle means "no upper
+// bound".
+func (idx *BrowseIndex) Range(fromTitle, toTitle string, limit int) ([]*NavElementView, error) {
+	var results []*NavElementView
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		byURI := tx.Bucket([]byte(byURIBucket))
+		byTitle := tx.Bucket([]byte(byTitleBucket))
+		until := normalizeSortTitle(toTitle)
+		c := byTitle.Cursor()
+		for k, v := c.Seek([]byte(normalizeSortTitle(fromTitle))); k != nil && len(results) < limit; k, v = c.Next() {
+			title := strings.SplitN(string(k), "\x00", 2)[0]
+			if until != "" && title > until {
+				break
+			}
+			if nav := navFromURI(byURI, string(v)); nav != nil {
+				results = append(results, nav)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// PrefixSearch returns up to limit browse entries whose normalized title
+// starts with q, in title order, suitable for typeahead.
+func (idx *BrowseIndex) PrefixSearch(q string, limit int) ([]*NavElementView, error) {
+	var results []*NavElementView
+	prefix := normalizeSortTitle(q)
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		byURI := tx.Bucket([]byte(byURIBucket))
+		byTitle := tx.Bucket([]byte(byTitleBucket))
+		c := byTitle.Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && len(results) < limit; k, v = c.Next() {
+			if !strings.HasPrefix(string(k), prefix) {
+				break
+			}
+			if nav := navFromURI(byURI, string(v)); nav != nil {
+				results = append(results, nav)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// FullMap streams the entire browse index into the map[uri]*NavElementView
+// shape MakeAccessionTitleIndex historically returned, with Prev/Next
+// populated from sorted title order, for callers that still want
+// everything in memory at once.
+func (idx *BrowseIndex) FullMap() (map[string]*NavElementView, error) {
+	result := make(map[string]*NavElementView)
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		byURI := tx.Bucket([]byte(byURIBucket))
+		byTitle := tx.Bucket([]byte(byTitleBucket))
+
+		var order []string
+		c := byTitle.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			order = append(order, string(v))
+		}
+
+		lastI := len(order) - 1
+		for i, uri := range order {
+			entry := navFromURI(byURI, uri)
+			if entry == nil {
+				continue
+			}
+			if i > 0 {
+				if prev := navFromURI(byURI, order[i-1]); prev != nil {
+					entry.PrevLabel = prev.ThisLabel
+					entry.PrevURI = prev.ThisURI
+				}
+			}
+			if i < lastI {
+				if next := navFromURI(byURI, order[i+1]); next != nil {
+					entry.NextLabel = next.ThisLabel
+					entry.NextURI = next.ThisURI
+				}
+			}
+			result[uri] = entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("No titles found")
+	}
+	return result, nil
+}
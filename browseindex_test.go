@@ -0,0 +1,117 @@
+package cait
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestBrowseIndexAddOrUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cait-browseindex-test")
+	if err != nil {
+		t.Fatalf("TempDir() failed, %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	idx, err := OpenBrowseIndex(path.Join(dir, BrowseIndexFilename))
+	if err != nil {
+		t.Fatalf("OpenBrowseIndex() failed, %s", err)
+	}
+	defer idx.Close()
+
+	older := time.Unix(1000, 0)
+	newer := time.Unix(2000, 0)
+
+	if err := idx.AddOrUpdate("/repositories/2/accessions/1", "Original Title", older); err != nil {
+		t.Fatalf("AddOrUpdate() failed, %s", err)
+	}
+
+	// A stale (not newer) mtime must be a no-op: the title stays the one
+	// already indexed.
+	if err := idx.AddOrUpdate("/repositories/2/accessions/1", "Stale Title", older); err != nil {
+		t.Fatalf("AddOrUpdate() failed, %s", err)
+	}
+	prev, next, err := idx.Neighbors("/repositories/2/accessions/1")
+	if err != nil {
+		t.Fatalf("Neighbors() failed, %s", err)
+	}
+	if prev != nil || next != nil {
+		t.Errorf("expected a lone entry to have no neighbors, got prev=%v next=%v", prev, next)
+	}
+	results, err := idx.PrefixSearch("Original", 10)
+	if err != nil {
+		t.Fatalf("PrefixSearch() failed, %s", err)
+	}
+	if len(results) != 1 || results[0].ThisLabel != "Original Title" {
+		t.Errorf("expected stale update to be skipped, got %+v", results)
+	}
+
+	// A genuinely newer mtime must replace the title (and its sort key).
+	if err := idx.AddOrUpdate("/repositories/2/accessions/1", "Updated Title", newer); err != nil {
+		t.Fatalf("AddOrUpdate() failed, %s", err)
+	}
+	results, err = idx.PrefixSearch("Updated", 10)
+	if err != nil {
+		t.Fatalf("PrefixSearch() failed, %s", err)
+	}
+	if len(results) != 1 || results[0].ThisLabel != "Updated Title" {
+		t.Errorf("expected newer mtime to update the title, got %+v", results)
+	}
+	if results, err := idx.PrefixSearch("Original", 10); err != nil || len(results) != 0 {
+		t.Errorf("expected old sort key to be removed, got %+v, %s", results, err)
+	}
+}
+
+func TestBrowseIndexNeighbors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cait-browseindex-test")
+	if err != nil {
+		t.Fatalf("TempDir() failed, %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	idx, err := OpenBrowseIndex(path.Join(dir, BrowseIndexFilename))
+	if err != nil {
+		t.Fatalf("OpenBrowseIndex() failed, %s", err)
+	}
+	defer idx.Close()
+
+	now := time.Unix(1000, 0)
+	entries := []struct {
+		uri   string
+		title string
+	}{
+		{"/repositories/2/accessions/1", "Banana Records"},
+		{"/repositories/2/accessions/2", "Apple Papers"},
+		{"/repositories/2/accessions/3", "Cherry Archive"},
+	}
+	for _, e := range entries {
+		if err := idx.AddOrUpdate(e.uri, e.title, now); err != nil {
+			t.Fatalf("AddOrUpdate(%s) failed, %s", e.uri, err)
+		}
+	}
+
+	// Sorted by (normalized) title this should read Apple, Banana, Cherry.
+	prev, next, err := idx.Neighbors("/repositories/2/accessions/1")
+	if err != nil {
+		t.Fatalf("Neighbors() failed, %s", err)
+	}
+	if prev == nil || prev.ThisURI != "/repositories/2/accessions/2" {
+		t.Errorf("expected Banana's prev to be Apple, got %+v", prev)
+	}
+	if next == nil || next.ThisURI != "/repositories/2/accessions/3" {
+		t.Errorf("expected Banana's next to be Cherry, got %+v", next)
+	}
+
+	prev, next, err = idx.Neighbors("/repositories/2/accessions/2")
+	if err != nil {
+		t.Fatalf("Neighbors() failed, %s", err)
+	}
+	if prev != nil {
+		t.Errorf("expected Apple (first in sort order) to have no prev, got %+v", prev)
+	}
+	if next == nil || next.ThisURI != "/repositories/2/accessions/1" {
+		t.Errorf("expected Apple's next to be Banana, got %+v", next)
+	}
+}
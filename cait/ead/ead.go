@@ -0,0 +1,162 @@
+//
+// Package ead renders cait's Accession records as EAD3 XML for archival
+// consumers that expect a finding aid rather than raw ArchivesSpace JSON.
+//
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package ead
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+
+	"../.."
+)
+
+// Namespace is the EAD3 schema namespace.
+const Namespace = "http://ead3.archivists.org/schema/"
+
+type daoXML struct {
+	Href string `xml:"href,attr"`
+}
+
+type physDescXML struct {
+	Extents []string `xml:"extent"`
+}
+
+type didXML struct {
+	UnitTitle string       `xml:"unittitle"`
+	UnitDate  string       `xml:"unitdate,omitempty"`
+	PhysDesc  *physDescXML `xml:"physdesc,omitempty"`
+	DAOs      []daoXML     `xml:"dao,omitempty"`
+}
+
+type pWrapXML struct {
+	P string `xml:"p"`
+}
+
+type controlAccessXML struct {
+	Subjects []string `xml:"subject"`
+}
+
+type archDescXML struct {
+	Level         string            `xml:"level,attr"`
+	DID           didXML            `xml:"did"`
+	ScopeContent  *pWrapXML         `xml:"scopecontent,omitempty"`
+	PhysTech      *pWrapXML         `xml:"phystech,omitempty"`
+	ControlAccess *controlAccessXML `xml:"controlaccess,omitempty"`
+}
+
+type eadXML struct {
+	XMLName  xml.Name    `xml:"ead"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	ArchDesc archDescXML `xml:"archdesc"`
+}
+
+// RenderEAD3 maps an Accession, its linked subjects and digital objects
+// onto a minimal valid EAD3 document: Title->unittitle,
+// AccessionDate->unitdate, Extents->physdesc/extent,
+// ContentDescription->scopecontent/p, ConditionDescription->phystech/p,
+// linked Subjects->controlaccess/subject and each linked digital
+// object's FileURIs->dao href.
+func RenderEAD3(accession *cait.Accession, subjects map[string]*cait.Subject, digitalObjects map[string]*cait.DigitalObject) ([]byte, error) {
+	if accession == nil {
+		return nil, fmt.Errorf("Can't render EAD3, accession is nil")
+	}
+
+	doc := &eadXML{
+		Xmlns: Namespace,
+		ArchDesc: archDescXML{
+			Level: "item",
+			DID: didXML{
+				UnitTitle: accession.Title,
+				UnitDate:  accession.AccessionDate,
+			},
+		},
+	}
+
+	for _, extent := range accession.Extents {
+		if extent.PhysicalDetails == "" {
+			continue
+		}
+		if doc.ArchDesc.DID.PhysDesc == nil {
+			doc.ArchDesc.DID.PhysDesc = &physDescXML{}
+		}
+		doc.ArchDesc.DID.PhysDesc.Extents = append(doc.ArchDesc.DID.PhysDesc.Extents, extent.PhysicalDetails)
+	}
+
+	if accession.ContentDescription != "" {
+		doc.ArchDesc.ScopeContent = &pWrapXML{P: accession.ContentDescription}
+	}
+	if accession.ConditionDescription != "" {
+		doc.ArchDesc.PhysTech = &pWrapXML{P: accession.ConditionDescription}
+	}
+
+	for _, item := range accession.Subjects {
+		ref, ok := item["ref"]
+		if !ok {
+			continue
+		}
+		refStr, ok := ref.(string)
+		if !ok {
+			continue
+		}
+		subject := subjects[refStr]
+		if subject == nil {
+			continue
+		}
+		if doc.ArchDesc.ControlAccess == nil {
+			doc.ArchDesc.ControlAccess = &controlAccessXML{}
+		}
+		doc.ArchDesc.ControlAccess.Subjects = append(doc.ArchDesc.ControlAccess.Subjects, subject.Title)
+	}
+
+	for _, instance := range accession.Instances {
+		m, ok := instance["digital_object"]
+		if !ok {
+			continue
+		}
+		kv := map[string]string{}
+		src, err := json.Marshal(m)
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(src, &kv); err != nil {
+			continue
+		}
+		ref, ok := kv["ref"]
+		if !ok {
+			continue
+		}
+		obj := digitalObjects[ref]
+		if obj == nil {
+			continue
+		}
+		for _, fv := range obj.FileVersions {
+			if fv.FileURI != "" {
+				doc.ArchDesc.DID.DAOs = append(doc.ArchDesc.DID.DAOs, daoXML{Href: fv.FileURI})
+			}
+		}
+	}
+
+	src, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), src...), nil
+}
@@ -0,0 +1,84 @@
+package ead
+
+import (
+	"strings"
+	"testing"
+
+	"../.."
+)
+
+func TestRenderEAD3(t *testing.T) {
+	if _, err := RenderEAD3(nil, nil, nil); err == nil {
+		t.Error("RenderEAD3(nil, ...) should return an error")
+	}
+
+	accession := &cait.Accession{
+		Title:                "Test Collection",
+		AccessionDate:        "2016-01-01",
+		ContentDescription:   "A collection of test records.",
+		ConditionDescription: "Good condition.",
+		Extents: []cait.Extent{
+			{PhysicalDetails: "1 box"},
+		},
+		Subjects: []map[string]interface{}{
+			{"ref": "/subjects/1"},
+			{"ref": "/subjects/2"},
+		},
+		Instances: []map[string]interface{}{
+			{"digital_object": map[string]interface{}{"ref": "/repositories/2/digital_objects/1"}},
+		},
+	}
+	subjects := map[string]*cait.Subject{
+		"/subjects/1": {Title: "Archives"},
+	}
+	digitalObjects := map[string]*cait.DigitalObject{
+		"/repositories/2/digital_objects/1": {
+			FileVersions: []cait.FileVersion{
+				{FileURI: "https://example.edu/do/1.pdf"},
+			},
+		},
+	}
+
+	src, err := RenderEAD3(accession, subjects, digitalObjects)
+	if err != nil {
+		t.Fatalf("RenderEAD3() failed, %s", err)
+	}
+	out := string(src)
+
+	for _, want := range []string{
+		"<unittitle>Test Collection</unittitle>",
+		"<unitdate>2016-01-01</unitdate>",
+		"<extent>1 box</extent>",
+		"<scopecontent>",
+		"<p>A collection of test records.</p>",
+		"<phystech>",
+		"<p>Good condition.</p>",
+		"<subject>Archives</subject>",
+		`<dao href="https://example.edu/do/1.pdf">`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("RenderEAD3() output missing %q, got %s", want, out)
+		}
+	}
+
+	// /subjects/2 isn't in the subjects map, so it should be silently
+	// skipped rather than producing a second <subject> entry.
+	if strings.Count(out, "<subject>") != 1 {
+		t.Errorf("RenderEAD3() expected exactly one <subject> element, got %s", out)
+	}
+}
+
+func TestRenderEAD3MinimalAccession(t *testing.T) {
+	accession := &cait.Accession{Title: "Bare Minimum"}
+	src, err := RenderEAD3(accession, nil, nil)
+	if err != nil {
+		t.Fatalf("RenderEAD3() failed, %s", err)
+	}
+	out := string(src)
+	if !strings.Contains(out, "<unittitle>Bare Minimum</unittitle>") {
+		t.Errorf("RenderEAD3() missing unittitle, got %s", out)
+	}
+	if strings.Contains(out, "<controlaccess>") {
+		t.Errorf("RenderEAD3() should omit controlaccess when there are no subjects, got %s", out)
+	}
+}
@@ -0,0 +1,102 @@
+//
+// dc.go - registers the oai_dc metadata format, mapping a
+// NormalizedAccessionView onto unqualified Dublin Core for dissemination
+// via the OAI-PMH provider.
+//
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package oai
+
+import (
+	"encoding/xml"
+
+	"../.."
+)
+
+// MetadataFormat describes a metadata format this provider can disseminate
+// along with the function used to render a normalized accession into it.
+// Additional formats (EAD, MODS, ...) register themselves with
+// RegisterFormat, typically from an init() in the package that implements
+// them.
+type MetadataFormat struct {
+	Prefix    string
+	Schema    string
+	Namespace string
+	Render    func(v *cait.NormalizedAccessionView) ([]byte, error)
+}
+
+var formats = map[string]*MetadataFormat{}
+
+// RegisterFormat adds (or replaces) a metadata format available via
+// ListMetadataFormats, ListRecords and GetRecord.
+func RegisterFormat(f *MetadataFormat) {
+	formats[f.Prefix] = f
+}
+
+func init() {
+	RegisterFormat(&MetadataFormat{
+		Prefix:    "oai_dc",
+		Schema:    "http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Namespace: "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		Render:    renderOAIDC,
+	})
+}
+
+// oaiDC is the oai_dc wrapper around unqualified Dublin Core elements.
+type oaiDC struct {
+	XMLName           xml.Name `xml:"oai_dc:dc"`
+	XmlnsOAIDC        string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC           string   `xml:"xmlns:dc,attr"`
+	XmlnsXsi          string   `xml:"xmlns:xsi,attr"`
+	XsiSchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title             []string `xml:"dc:title,omitempty"`
+	Creator           []string `xml:"dc:creator,omitempty"`
+	Subject           []string `xml:"dc:subject,omitempty"`
+	Date              []string `xml:"dc:date,omitempty"`
+	Identifier        []string `xml:"dc:identifier,omitempty"`
+	Relation          []string `xml:"dc:relation,omitempty"`
+}
+
+// renderOAIDC maps a NormalizedAccessionView onto oai_dc per the
+// request's field mapping: Title->dc:title, Subjects->dc:subject,
+// CreatedBy->dc:creator, AccessionDate->dc:date, URI->dc:identifier and
+// each digital object's FileURIs->dc:relation.
+func renderOAIDC(v *cait.NormalizedAccessionView) ([]byte, error) {
+	dc := &oaiDC{
+		XmlnsOAIDC:        "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		XmlnsDC:           "http://purl.org/dc/elements/1.1/",
+		XmlnsXsi:          "http://www.w3.org/2001/XMLSchema-instance",
+		XsiSchemaLocation: "http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+	}
+	if v.Title != "" {
+		dc.Title = append(dc.Title, v.Title)
+	}
+	dc.Subject = append(dc.Subject, v.Subjects...)
+	if v.CreatedBy != "" {
+		dc.Creator = append(dc.Creator, v.CreatedBy)
+	}
+	if v.AccessionDate != "" {
+		dc.Date = append(dc.Date, v.AccessionDate)
+	}
+	if v.URI != "" {
+		dc.Identifier = append(dc.Identifier, v.URI)
+	}
+	for _, obj := range v.DigitalObjects {
+		dc.Relation = append(dc.Relation, obj.FileURIs...)
+	}
+	return xml.Marshal(dc)
+}
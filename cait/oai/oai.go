@@ -0,0 +1,498 @@
+//
+// Package oai implements an OAI-PMH 2.0 data provider over the records
+// cait normalizes out of a harvested ArchivesSpace dataset. It serves the
+// six required OAI-PMH verbs (Identify, ListMetadataFormats, ListSets,
+// ListIdentifiers, ListRecords, GetRecord) so aggregators like DPLA,
+// Primo or Blacklight can harvest the cache directly instead of the
+// static JSON export.
+//
+//
+// @author R. S. Doiel, <rsdoiel@caltech.edu>
+//
+// Copyright (c) 2016, Caltech
+// All rights not granted herein are expressly reserved by Caltech.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package oai
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"../.."
+)
+
+// DefaultPageSize is used when a Config does not set PageSize.
+const DefaultPageSize = 100
+
+// Namespace is the XML namespace of the OAI-PMH 2.0 envelope.
+const Namespace = "http://www.openarchives.org/OAI/2.0/"
+
+// Config holds the settings needed to build a Provider.
+type Config struct {
+	// RepositoryName is returned in the Identify response.
+	RepositoryName string
+	// BaseURL is this provider's own endpoint, returned in Identify and request echoes.
+	BaseURL string
+	// AdminEmail is returned in the Identify response.
+	AdminEmail string
+	// DatasetsPath is $CAIT_DATASETS, walked for accession records.
+	DatasetsPath string
+	// SubjectsPath is the directory of subject records, e.g. $CAIT_DATASETS/subjects.
+	SubjectsPath string
+	// DigitalObjectsPath is the directory of digital object records, e.g. $CAIT_DATASETS/digital_objects.
+	DigitalObjectsPath string
+	// PageSize caps the number of records returned per ListIdentifiers/ListRecords response.
+	PageSize int
+}
+
+// Provider serves OAI-PMH 2.0 over a cait dataset normalized into
+// NormalizedAccessionView records, browsable via the sorted title index
+// built by cait.MakeAccessionTitleIndex.
+type Provider struct {
+	Config *Config
+
+	index   map[string]*cait.NavElementView
+	views   map[string]*cait.NormalizedAccessionView
+	headURI string
+}
+
+// New walks Config's dataset paths once, building the title index and the
+// normalized views served by the OAI-PMH verbs.
+func New(config *Config) (*Provider, error) {
+	if config.PageSize <= 0 {
+		config.PageSize = DefaultPageSize
+	}
+	index, err := cait.MakeAccessionTitleIndex(config.DatasetsPath)
+	if err != nil {
+		return nil, fmt.Errorf("Can't build title index from %s, %s", config.DatasetsPath, err)
+	}
+	accessions, err := cait.MakeAccessionMap(config.DatasetsPath)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read accessions from %s, %s", config.DatasetsPath, err)
+	}
+	subjects, err := cait.MakeSubjectMap(config.SubjectsPath)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read subjects from %s, %s", config.SubjectsPath, err)
+	}
+	digitalObjects, err := cait.MakeDigitalObjectMap(config.DigitalObjectsPath)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read digital objects from %s, %s", config.DigitalObjectsPath, err)
+	}
+
+	p := &Provider{
+		Config: config,
+		index:  index,
+		views:  make(map[string]*cait.NormalizedAccessionView, len(accessions)),
+	}
+	for uri, accession := range accessions {
+		v, err := accession.NormalizeView(subjects, digitalObjects)
+		if err != nil {
+			return nil, fmt.Errorf("Can't normalize %s, %s", uri, err)
+		}
+		p.views[uri] = v
+	}
+	for uri, nav := range index {
+		if nav.PrevURI == "" {
+			p.headURI = uri
+			break
+		}
+	}
+	return p, nil
+}
+
+// cursor is the opaque state carried by a resumptionToken. It is
+// serialized as base64 encoded JSON so it round trips through a client
+// without the client having to resend the original request's filters.
+type cursor struct {
+	URI    string `json:"uri,omitempty"`
+	Set    string `json:"set,omitempty"`
+	From   string `json:"from,omitempty"`
+	Until  string `json:"until,omitempty"`
+	Prefix string `json:"metadataPrefix,omitempty"`
+}
+
+func encodeToken(c *cursor) string {
+	src, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.URLEncoding.EncodeToString(src)
+}
+
+func decodeToken(tok string) (*cursor, error) {
+	src, err := base64.URLEncoding.DecodeString(tok)
+	if err != nil {
+		return nil, err
+	}
+	c := new(cursor)
+	if err := json.Unmarshal(src, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setSpecForURI derives an OAI setSpec from an accession's repository URI
+// prefix, e.g. "/repositories/2/accessions/1234" -> "repository_2".
+func setSpecForURI(uri string) string {
+	parts := strings.Split(strings.Trim(uri, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "repositories" {
+		return fmt.Sprintf("repository_%s", parts[1])
+	}
+	return ""
+}
+
+// matches reports whether the record at uri satisfies the set/from/until
+// filters carried by c.
+//
+//FIXME: From/Until are compared lexicographically against LastModified
+// rather than being time.Parse'd, which only works when LastModified is
+// stored in a sortable (e.g. ISO 8601) layout.
+func (p *Provider) matches(uri string, c *cursor) bool {
+	if c.Set != "" && setSpecForURI(uri) != c.Set {
+		return false
+	}
+	v, ok := p.views[uri]
+	if !ok {
+		return false
+	}
+	if c.From != "" && v.LastModified < c.From {
+		return false
+	}
+	if c.Until != "" && v.LastModified > c.Until {
+		return false
+	}
+	return true
+}
+
+// listURIs pages through the title index starting at c.URI (or the head
+// of the index when c.URI is empty), returning up to PageSize URIs that
+// satisfy c's filters plus a resumption token for the next page, if any.
+// It errors if c.URI came from a resumption token whose cursor no longer
+// resolves in the index (stale token, or the record was removed between
+// harvests), rather than silently handing back the same token forever.
+func (p *Provider) listURIs(c *cursor) ([]string, string, error) {
+	var uris []string
+	uri := c.URI
+	if uri == "" {
+		uri = p.headURI
+	} else if _, ok := p.index[uri]; !ok {
+		return nil, "", fmt.Errorf("resumption token cursor %q no longer resolves in the index", uri)
+	}
+	for uri != "" && len(uris) < p.Config.PageSize {
+		nav, ok := p.index[uri]
+		if !ok {
+			break
+		}
+		if p.matches(uri, c) {
+			uris = append(uris, uri)
+		}
+		uri = nav.NextURI
+	}
+	if uri == "" {
+		return uris, "", nil
+	}
+	next := *c
+	next.URI = uri
+	return uris, encodeToken(&next), nil
+}
+
+// ListSets returns the distinct setSpecs derived from the repository URI
+// prefixes present in the dataset.
+func (p *Provider) ListSets() []string {
+	seen := make(map[string]bool)
+	var sets []string
+	for uri := range p.views {
+		setSpec := setSpecForURI(uri)
+		if setSpec != "" && !seen[setSpec] {
+			seen[setSpec] = true
+			sets = append(sets, setSpec)
+		}
+	}
+	return sets
+}
+
+//
+// HTTP handling
+//
+
+type oaiRequest struct {
+	Verb           string `xml:"verb,attr,omitempty"`
+	Identifier     string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix string `xml:"metadataPrefix,attr,omitempty"`
+	Value          string `xml:",chardata"`
+}
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type metadataFormatXML struct {
+	MetadataPrefix    string `xml:"metadataPrefix"`
+	Schema            string `xml:"schema"`
+	MetadataNamespace string `xml:"metadataNamespace"`
+}
+
+type setXML struct {
+	SetSpec string `xml:"setSpec"`
+	SetName string `xml:"setName"`
+}
+
+type headerXML struct {
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpec    []string `xml:"setSpec,omitempty"`
+}
+
+type metadataXML struct {
+	XML []byte `xml:",innerxml"`
+}
+
+type recordXML struct {
+	Header   headerXML    `xml:"header"`
+	Metadata *metadataXML `xml:"metadata,omitempty"`
+}
+
+type resumptionTokenXML struct {
+	Value string `xml:",chardata"`
+}
+
+type envelope struct {
+	XMLName             xml.Name            `xml:"OAI-PMH"`
+	Xmlns               string              `xml:"xmlns,attr"`
+	XmlnsXsi            string              `xml:"xmlns:xsi,attr"`
+	XsiSchemaLocation   string              `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string              `xml:"responseDate"`
+	Request             oaiRequest          `xml:"request"`
+	Error               *oaiError           `xml:"error,omitempty"`
+	Identify            *identifyXML        `xml:"Identify,omitempty"`
+	ListMetadataFormats *metadataFormatsXML `xml:"ListMetadataFormats,omitempty"`
+	ListSets            *setsXML            `xml:"ListSets,omitempty"`
+	ListIdentifiers     *listXML            `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *listXML            `xml:"ListRecords,omitempty"`
+	GetRecord           *getRecordXML       `xml:"GetRecord,omitempty"`
+}
+
+type identifyXML struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type metadataFormatsXML struct {
+	Formats []metadataFormatXML `xml:"metadataFormat"`
+}
+
+type setsXML struct {
+	Sets []setXML `xml:"set"`
+}
+
+type listXML struct {
+	Headers         []headerXML         `xml:"header,omitempty"`
+	Records         []recordXML         `xml:"record,omitempty"`
+	ResumptionToken *resumptionTokenXML `xml:"resumptionToken,omitempty"`
+}
+
+type getRecordXML struct {
+	Record recordXML `xml:"record"`
+}
+
+func newEnvelope(req *http.Request) *envelope {
+	return &envelope{
+		Xmlns:             Namespace,
+		XmlnsXsi:          "http://www.w3.org/2001/XMLSchema-instance",
+		XsiSchemaLocation: Namespace + " http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd",
+		ResponseDate:      time.Now().UTC().Format(time.RFC3339),
+		Request: oaiRequest{
+			Verb:           req.FormValue("verb"),
+			Identifier:     req.FormValue("identifier"),
+			MetadataPrefix: req.FormValue("metadataPrefix"),
+		},
+	}
+}
+
+func writeError(w http.ResponseWriter, env *envelope, code, message string) {
+	env.Error = &oaiError{Code: code, Message: message}
+	writeXML(w, env)
+}
+
+func writeXML(w http.ResponseWriter, env *envelope) {
+	w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(env)
+}
+
+// ServeHTTP dispatches verb based on the "verb" query string parameter,
+// implementing Identify, ListMetadataFormats, ListSets, ListIdentifiers,
+// ListRecords and GetRecord.
+func (p *Provider) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	env := newEnvelope(req)
+	env.Request.Value = p.Config.BaseURL
+	verb := req.FormValue("verb")
+	switch verb {
+	case "Identify":
+		p.identify(env)
+	case "ListMetadataFormats":
+		p.listMetadataFormats(env)
+	case "ListSets":
+		p.listSets(env)
+	case "ListIdentifiers":
+		p.listIdentifiersOrRecords(w, env, req, false)
+		return
+	case "ListRecords":
+		p.listIdentifiersOrRecords(w, env, req, true)
+		return
+	case "GetRecord":
+		p.getRecord(w, env, req)
+		return
+	default:
+		writeError(w, env, "badVerb", fmt.Sprintf("%q is not a legal OAI-PMH verb", verb))
+		return
+	}
+	writeXML(w, env)
+}
+
+func (p *Provider) identify(env *envelope) {
+	env.Identify = &identifyXML{
+		RepositoryName:    p.Config.RepositoryName,
+		BaseURL:           p.Config.BaseURL,
+		ProtocolVersion:   "2.0",
+		AdminEmail:        p.Config.AdminEmail,
+		EarliestDatestamp: "1970-01-01T00:00:00Z",
+		DeletedRecord:     "no",
+		Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+	}
+}
+
+func (p *Provider) listMetadataFormats(env *envelope) {
+	formatsXML := &metadataFormatsXML{}
+	for _, f := range formats {
+		formatsXML.Formats = append(formatsXML.Formats, metadataFormatXML{
+			MetadataPrefix:    f.Prefix,
+			Schema:            f.Schema,
+			MetadataNamespace: f.Namespace,
+		})
+	}
+	env.ListMetadataFormats = formatsXML
+}
+
+func (p *Provider) listSets(env *envelope) {
+	setsXML := &setsXML{}
+	for _, setSpec := range p.ListSets() {
+		setsXML.Sets = append(setsXML.Sets, setXML{SetSpec: setSpec, SetName: setSpec})
+	}
+	env.ListSets = setsXML
+}
+
+func (p *Provider) cursorFromRequest(req *http.Request) (*cursor, error) {
+	if tok := req.FormValue("resumptionToken"); tok != "" {
+		return decodeToken(tok)
+	}
+	return &cursor{
+		Set:    req.FormValue("set"),
+		From:   req.FormValue("from"),
+		Until:  req.FormValue("until"),
+		Prefix: req.FormValue("metadataPrefix"),
+	}, nil
+}
+
+func (p *Provider) listIdentifiersOrRecords(w http.ResponseWriter, env *envelope, req *http.Request, withMetadata bool) {
+	c, err := p.cursorFromRequest(req)
+	if err != nil {
+		writeError(w, env, "badResumptionToken", err.Error())
+		return
+	}
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = req.FormValue("metadataPrefix")
+	}
+	format, ok := formats[prefix]
+	if !ok {
+		writeError(w, env, "cannotDisseminateFormat", fmt.Sprintf("%q is not a supported metadataPrefix", prefix))
+		return
+	}
+	uris, token, err := p.listURIs(c)
+	if err != nil {
+		writeError(w, env, "badResumptionToken", err.Error())
+		return
+	}
+	if len(uris) == 0 {
+		writeError(w, env, "noRecordsMatch", "no records match the given criteria")
+		return
+	}
+	list := &listXML{}
+	if token != "" {
+		list.ResumptionToken = &resumptionTokenXML{Value: token}
+	}
+	for _, uri := range uris {
+		v := p.views[uri]
+		header := headerXML{Identifier: v.URI, Datestamp: v.LastModified}
+		if setSpec := setSpecForURI(uri); setSpec != "" {
+			header.SetSpec = []string{setSpec}
+		}
+		if !withMetadata {
+			list.Headers = append(list.Headers, header)
+			continue
+		}
+		md, err := format.Render(v)
+		if err != nil {
+			writeError(w, env, "cannotDisseminateFormat", err.Error())
+			return
+		}
+		list.Records = append(list.Records, recordXML{Header: header, Metadata: &metadataXML{XML: md}})
+	}
+	if withMetadata {
+		env.ListRecords = list
+	} else {
+		env.ListIdentifiers = list
+	}
+	writeXML(w, env)
+}
+
+func (p *Provider) getRecord(w http.ResponseWriter, env *envelope, req *http.Request) {
+	identifier := req.FormValue("identifier")
+	v, ok := p.views[identifier]
+	if !ok {
+		writeError(w, env, "idDoesNotExist", fmt.Sprintf("%q is not known to this repository", identifier))
+		return
+	}
+	prefix := req.FormValue("metadataPrefix")
+	format, ok := formats[prefix]
+	if !ok {
+		writeError(w, env, "cannotDisseminateFormat", fmt.Sprintf("%q is not a supported metadataPrefix", prefix))
+		return
+	}
+	md, err := format.Render(v)
+	if err != nil {
+		writeError(w, env, "cannotDisseminateFormat", err.Error())
+		return
+	}
+	header := headerXML{Identifier: v.URI, Datestamp: v.LastModified}
+	if setSpec := setSpecForURI(identifier); setSpec != "" {
+		header.SetSpec = []string{setSpec}
+	}
+	env.GetRecord = &getRecordXML{Record: recordXML{Header: header, Metadata: &metadataXML{XML: md}}}
+	writeXML(w, env)
+}
@@ -0,0 +1,73 @@
+package oai
+
+import (
+	"testing"
+
+	"../.."
+)
+
+func TestSetSpecForURI(t *testing.T) {
+	testCases := []struct {
+		uri  string
+		want string
+	}{
+		{"/repositories/2/accessions/1234", "repository_2"},
+		{"/repositories/10/resources/5", "repository_10"},
+		{"/subjects/1", ""},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		if got := setSpecForURI(tc.uri); got != tc.want {
+			t.Errorf("setSpecForURI(%q) = %q, want %q", tc.uri, got, tc.want)
+		}
+	}
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	c := &cursor{URI: "/repositories/2/accessions/1234", Set: "repository_2", From: "2016-01-01", Prefix: "oai_dc"}
+	tok := encodeToken(c)
+	if tok == "" {
+		t.Fatal("encodeToken() returned an empty token")
+	}
+	got, err := decodeToken(tok)
+	if err != nil {
+		t.Fatalf("decodeToken() failed, %s", err)
+	}
+	if *got != *c {
+		t.Errorf("decodeToken(encodeToken(c)) = %+v, want %+v", got, c)
+	}
+
+	if _, err := decodeToken("not valid base64!!"); err == nil {
+		t.Error("decodeToken() on garbage input should return an error")
+	}
+}
+
+func TestProviderMatches(t *testing.T) {
+	p := &Provider{
+		Config: &Config{},
+		views: map[string]*cait.NormalizedAccessionView{
+			"/repositories/2/accessions/1": {LastModified: "2016-06-01"},
+			"/repositories/3/accessions/2": {LastModified: "2016-01-01"},
+		},
+	}
+
+	testCases := []struct {
+		name string
+		uri  string
+		c    *cursor
+		want bool
+	}{
+		{"no filters", "/repositories/2/accessions/1", &cursor{}, true},
+		{"matching set", "/repositories/2/accessions/1", &cursor{Set: "repository_2"}, true},
+		{"non-matching set", "/repositories/2/accessions/1", &cursor{Set: "repository_3"}, false},
+		{"within from/until", "/repositories/2/accessions/1", &cursor{From: "2016-01-01", Until: "2016-12-31"}, true},
+		{"before from", "/repositories/3/accessions/2", &cursor{From: "2016-06-01"}, false},
+		{"after until", "/repositories/2/accessions/1", &cursor{Until: "2016-01-01"}, false},
+		{"unknown uri", "/repositories/9/accessions/9", &cursor{}, false},
+	}
+	for _, tc := range testCases {
+		if got := p.matches(tc.uri, tc.c); got != tc.want {
+			t.Errorf("%s: matches(%q, %+v) = %t, want %t", tc.name, tc.uri, tc.c, got, tc.want)
+		}
+	}
+}
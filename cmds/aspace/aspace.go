@@ -5,6 +5,8 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -13,7 +15,9 @@ import (
 	"path"
 	"strings"
 
+	"../.."
 	"../../../gospace"
+	"../../cait/ead"
 )
 
 type command struct {
@@ -23,6 +27,16 @@ type command struct {
 	Options []string
 }
 
+// bulkResult describes the outcome of a single record processed in NDJSON
+// bulk mode so it can be streamed back to the operator one line at a time.
+type bulkResult struct {
+	Subject string `json:"subject,omitempty"`
+	Action  string `json:"action,omitempty"`
+	OK      bool   `json:"ok"`
+	Result  string `json:"result,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
 var (
 	help    = flag.Bool("help", false, "Display the help page")
 	payload = flag.String("input", "", "Use this filepath for the payload")
@@ -101,6 +115,20 @@ func usage(msg string, exitCode int) {
 
     %s repository list '{"id": 2}'
 
+  %s also supports a bulk/streaming mode. If -input points at a file of
+  newline delimited JSON (NDJSON) command records, one JSON object per
+  line with "subject", "action" and an optional "payload" string (the
+  parts of "%s SUBJECT ACTION [PAYLOAD]"), each line is run as its own
+  command against the same authenticated session and the result is
+  streamed back as NDJSON, one line per record. The exit code is non-zero
+  if any record failed.
+
+  %s accession export also supports rendering a finding aid straight from
+  the locally harvested $CAIT_DATASETS cache, bypassing ArchivesSpace
+  entirely
+
+    %s accession export --format=ead3 '/repositories/2/accessions/1234'
+
 `,
 		appName,
 		os.Getenv("ASPACE_PROTOCOL"),
@@ -110,6 +138,10 @@ func usage(msg string, exitCode int) {
 		os.Getenv("ASPACE_PASSWORD"),
 		appName,
 		appName,
+		appName,
+		appName,
+		appName,
+		appName,
 		appName)
 
 	if msg != "" {
@@ -137,6 +169,16 @@ func configureApp() (map[string]string, error) {
 	return conf, nil
 }
 
+// newSession authenticates once and returns the API handle used for the
+// lifetime of the process, so bulk runs share a single login.
+func newSession(config map[string]string) (*gospace.API, error) {
+	api := gospace.New(config["ASPACE_PROTOCOL"], config["ASPACE_HOST"], config["ASPACE_PORT"], config["ASPACE_USERNAME"], config["ASPACE_PASSWORD"])
+	if err := api.Login(); err != nil {
+		return nil, err
+	}
+	return api, nil
+}
+
 func containsElement(src []string, elem string) bool {
 	for _, item := range src {
 		if strings.Compare(item, elem) == 0 {
@@ -163,17 +205,33 @@ func parseCmd(args []string) (*command, error) {
 	}
 
 	cmd.Action = args[1]
-	if len(args) > 2 {
-		cmd.Payload = strings.Join(args[2:], " ")
+	var remainder []string
+	for _, arg := range args[2:] {
+		if strings.HasPrefix(arg, "--") {
+			cmd.Options = append(cmd.Options, strings.TrimPrefix(arg, "--"))
+			continue
+		}
+		remainder = append(remainder, arg)
+	}
+	if len(remainder) > 0 {
+		cmd.Payload = strings.Join(remainder, " ")
 	}
 	return cmd, nil
 }
 
-func runRepoCmd(cmd *command, config map[string]string) (string, error) {
-	api := gospace.New(config["ASPACE_PROTOCOL"], config["ASPACE_HOST"], config["ASPACE_PORT"], config["ASPACE_USERNAME"], config["ASPACE_PASSWORD"])
-	if err := api.Login(); err != nil {
-		return "", err
+// optionValue returns the value of a "--key=value" style option passed
+// on the command line, or "" if key wasn't set.
+func (c *command) optionValue(key string) string {
+	prefix := key + "="
+	for _, opt := range c.Options {
+		if strings.HasPrefix(opt, prefix) {
+			return strings.TrimPrefix(opt, prefix)
+		}
 	}
+	return ""
+}
+
+func runRepoCmd(api *gospace.API, cmd *command) (string, error) {
 	switch cmd.Action {
 	case "create":
 		repo := new(gospace.Repository)
@@ -253,16 +311,283 @@ func runRepoCmd(cmd *command, config map[string]string) (string, error) {
 	return "", fmt.Errorf("action %s not implemented for %s", cmd.Action, cmd.Subject)
 }
 
-func runCmd(cmd *command, config map[string]string) (string, error) {
+func runAgentCmd(api *gospace.API, cmd *command) (string, error) {
+	switch cmd.Action {
+	case "create":
+		agent := new(gospace.Agent)
+		err := json.Unmarshal([]byte(cmd.Payload), agent)
+		agent, err = api.CreateAgent(agent)
+		if err != nil {
+			return "", err
+		}
+		src, err := json.Marshal(agent)
+		if err != nil {
+			return "", err
+		}
+		return string(src), nil
+	case "list":
+		if cmd.Payload == "" {
+			agents, err := api.ListAgents()
+			if err != nil {
+				return "", fmt.Errorf(`{"status": "error", "message": "%s"}`, err)
+			}
+			src, err := json.Marshal(agents)
+			if err != nil {
+				return "", fmt.Errorf(`{"status": "error", "message": "Cannot JSON encode %s %s"}`, cmd.Payload, err)
+			}
+			return string(src), nil
+		}
+		agent := new(gospace.Agent)
+		err := json.Unmarshal([]byte(cmd.Payload), &agent)
+		if err != nil {
+			return "", err
+		}
+		agent, err = api.GetAgent(agent.ID)
+		if err != nil {
+			return "", fmt.Errorf(`{"status": "error", "message": "%s"}`, err)
+		}
+		src, err := json.Marshal(agent)
+		if err != nil {
+			return "", fmt.Errorf(`{"status": "error", "message": "Cannot find %s %s"}`, cmd.Payload, err)
+		}
+		return string(src), nil
+	case "update":
+		agent := new(gospace.Agent)
+		err := json.Unmarshal([]byte(cmd.Payload), &agent)
+		if err != nil {
+			return "", err
+		}
+		responseMsg, err := api.UpdateAgent(agent)
+		if err != nil {
+			return "", err
+		}
+		src, err := json.Marshal(responseMsg)
+		return string(src), err
+	case "delete":
+		agent := new(gospace.Agent)
+		err := json.Unmarshal([]byte(cmd.Payload), &agent)
+		if err != nil {
+			return "", err
+		}
+		agent, err = api.GetAgent(agent.ID)
+		if err != nil {
+			return "", err
+		}
+		responseMsg, err := api.DeleteAgent(agent)
+		if err != nil {
+			return "", err
+		}
+		src, err := json.Marshal(responseMsg)
+		return string(src), err
+	case "export":
+		return "", api.ExportAgent(cmd.Payload)
+	case "import":
+		return "", api.ImportAgent(cmd.Payload)
+	}
+	return "", fmt.Errorf("action %s not implemented for %s", cmd.Action, cmd.Subject)
+}
+
+func runAccessionCmd(api *gospace.API, cmd *command) (string, error) {
+	switch cmd.Action {
+	case "create":
+		accession := new(gospace.Accession)
+		err := json.Unmarshal([]byte(cmd.Payload), accession)
+		accession, err = api.CreateAccession(accession)
+		if err != nil {
+			return "", err
+		}
+		src, err := json.Marshal(accession)
+		if err != nil {
+			return "", err
+		}
+		return string(src), nil
+	case "list":
+		if cmd.Payload == "" {
+			accessions, err := api.ListAccessions()
+			if err != nil {
+				return "", fmt.Errorf(`{"status": "error", "message": "%s"}`, err)
+			}
+			src, err := json.Marshal(accessions)
+			if err != nil {
+				return "", fmt.Errorf(`{"status": "error", "message": "Cannot JSON encode %s %s"}`, cmd.Payload, err)
+			}
+			return string(src), nil
+		}
+		accession := new(gospace.Accession)
+		err := json.Unmarshal([]byte(cmd.Payload), &accession)
+		if err != nil {
+			return "", err
+		}
+		accession, err = api.GetAccession(accession.ID)
+		if err != nil {
+			return "", fmt.Errorf(`{"status": "error", "message": "%s"}`, err)
+		}
+		src, err := json.Marshal(accession)
+		if err != nil {
+			return "", fmt.Errorf(`{"status": "error", "message": "Cannot find %s %s"}`, cmd.Payload, err)
+		}
+		return string(src), nil
+	case "update":
+		accession := new(gospace.Accession)
+		err := json.Unmarshal([]byte(cmd.Payload), &accession)
+		if err != nil {
+			return "", err
+		}
+		responseMsg, err := api.UpdateAccession(accession)
+		if err != nil {
+			return "", err
+		}
+		src, err := json.Marshal(responseMsg)
+		return string(src), err
+	case "delete":
+		accession := new(gospace.Accession)
+		err := json.Unmarshal([]byte(cmd.Payload), &accession)
+		if err != nil {
+			return "", err
+		}
+		accession, err = api.GetAccession(accession.ID)
+		if err != nil {
+			return "", err
+		}
+		responseMsg, err := api.DeleteAccession(accession)
+		if err != nil {
+			return "", err
+		}
+		src, err := json.Marshal(responseMsg)
+		return string(src), err
+	case "export":
+		return "", api.ExportAccession(cmd.Payload)
+	case "import":
+		return "", api.ImportAccession(cmd.Payload)
+	}
+	return "", fmt.Errorf("action %s not implemented for %s", cmd.Action, cmd.Subject)
+}
+
+// runAccessionExport handles "accession export --format=FORMAT" requests
+// that render from the locally harvested $CAIT_DATASETS cache instead of
+// calling out to ArchivesSpace, so sites can produce finding aids without
+// a second toolchain. cmd.Payload holds the accession's URI.
+func runAccessionExport(cmd *command, format string) (string, error) {
+	datasetsPath := os.Getenv("CAIT_DATASETS")
+	if datasetsPath == "" {
+		return "", fmt.Errorf("CAIT_DATASETS is undefined in the enviroment (e.g. try export CAIT_DATASETS=SOME_VALUE_FOR_CAIT_DATASETS)")
+	}
+	switch format {
+	case "ead3":
+		accessions, err := cait.MakeAccessionMap(datasetsPath)
+		if err != nil {
+			return "", err
+		}
+		accession, ok := accessions[cmd.Payload]
+		if !ok {
+			return "", fmt.Errorf("%s not found in %s", cmd.Payload, datasetsPath)
+		}
+		subjects, err := cait.MakeSubjectMap(path.Join(datasetsPath, "subjects"))
+		if err != nil {
+			return "", err
+		}
+		digitalObjects, err := cait.MakeDigitalObjectMap(path.Join(datasetsPath, "digital_objects"))
+		if err != nil {
+			return "", err
+		}
+		src, err := ead.RenderEAD3(accession, subjects, digitalObjects)
+		if err != nil {
+			return "", err
+		}
+		return string(src), nil
+	}
+	return "", fmt.Errorf("export format %s not supported", format)
+}
+
+func runCmd(api *gospace.API, cmd *command) (string, error) {
+	if cmd.Subject == "accession" && cmd.Action == "export" {
+		if format := cmd.optionValue("format"); format != "" {
+			return runAccessionExport(cmd, format)
+		}
+	}
 	switch cmd.Subject {
 	case "repository":
-		return runRepoCmd(cmd, config)
+		return runRepoCmd(api, cmd)
 	case "instance":
-		return runRepoCmd(cmd, config)
+		return runRepoCmd(api, cmd)
+	case "agent":
+		return runAgentCmd(api, cmd)
+	case "accession":
+		return runAccessionCmd(api, cmd)
 	}
 	return "", fmt.Errorf("%s %s not implemented", cmd.Subject, cmd.Action)
 }
 
+// isNDJSON reports whether src looks like newline delimited JSON records
+// (more than one non-blank line, each independently valid JSON) rather than
+// a single JSON payload that merely happens to span multiple lines.
+func isNDJSON(src []byte) bool {
+	lines := strings.Split(strings.TrimSpace(string(src)), "\n")
+	recCount := 0
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			return false
+		}
+		recCount++
+	}
+	return recCount > 1
+}
+
+// runBulk reads an NDJSON stream of command records from src, running each
+// one against the same authenticated session api and writing an NDJSON
+// result for each record to stdout. It returns the number of records that
+// failed.
+func runBulk(api *gospace.API, src []byte) int {
+	failCount := 0
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	scanner.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		result := new(bulkResult)
+		cmd := new(command)
+		if err := json.Unmarshal([]byte(line), cmd); err != nil {
+			result.Error = fmt.Sprintf("Cannot parse record: %s", err)
+			failCount++
+			emitResult(result)
+			continue
+		}
+		result.Subject = cmd.Subject
+		result.Action = cmd.Action
+		out, err := runCmd(api, cmd)
+		if err != nil {
+			result.Error = err.Error()
+			failCount++
+		} else {
+			result.OK = true
+			result.Result = out
+		}
+		emitResult(result)
+	}
+	if err := scanner.Err(); err != nil {
+		result := &bulkResult{Error: fmt.Sprintf("Bulk input scan stopped early: %s", err)}
+		failCount++
+		emitResult(result)
+	}
+	return failCount
+}
+
+func emitResult(result *bulkResult) {
+	src, err := json.Marshal(result)
+	if err != nil {
+		fmt.Printf(`{"ok": false, "error": "Cannot encode result, %s"}`+"\n", err)
+		return
+	}
+	fmt.Println(string(src))
+}
+
 func (c *command) String() string {
 	src, err := json.Marshal(c)
 	if err != nil {
@@ -275,6 +600,13 @@ func init() {
 	flag.StringVar(payload, "i", "", "Use this filepath for the payload")
 }
 
+// isLocalExport reports whether cmd can be satisfied entirely from the
+// locally harvested $CAIT_DATASETS cache, with no ArchivesSpace session
+// required.
+func isLocalExport(cmd *command) bool {
+	return cmd.Subject == "accession" && cmd.Action == "export" && cmd.optionValue("format") != ""
+}
+
 func main() {
 	flag.Parse()
 
@@ -284,31 +616,69 @@ func main() {
 		usage("", 0)
 	}
 
+	if *payload != "" {
+		src, err := ioutil.ReadFile(*payload)
+		if err != nil {
+			usage(fmt.Sprintf("Cannot read %s", *payload), 1)
+		}
+		if isNDJSON(src) {
+			config, err := configureApp()
+			if err != nil {
+				usage(fmt.Sprintf("%s", err), 1)
+			}
+			api, err := newSession(config)
+			if err != nil {
+				usage(fmt.Sprintf("%s", err), 1)
+			}
+			failCount := runBulk(api, src)
+			if failCount > 0 {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		if len(args) < 2 {
+			usage("aspace is a command line tool for interacting with an ArchivesSpace installation.", 1)
+		}
+		cmd, err := parseCmd(args)
+		if err != nil {
+			usage(fmt.Sprintf("%s", err), 1)
+		}
+		cmd.Payload = string(src)
+		runAndPrint(cmd)
+		return
+	}
+
 	if len(args) < 2 {
 		usage("aspace is a command line tool for interacting with an ArchivesSpace installation.", 1)
 	}
-	config, err := configureApp()
-	if err != nil {
-		usage(fmt.Sprintf("%s", err), 1)
-	}
 	cmd, err := parseCmd(args)
 	if err != nil {
 		usage(fmt.Sprintf("%s", err), 1)
 	}
 
-	if *payload != "" {
-		src, err := ioutil.ReadFile(*payload)
+	runAndPrint(cmd)
+}
+
+// runAndPrint authenticates an ArchivesSpace session (unless cmd can be
+// satisfied locally, e.g. "accession export --format=ead3"), runs cmd,
+// prints the result and exits with a non-zero status on error.
+func runAndPrint(cmd *command) {
+	var api *gospace.API
+	if !isLocalExport(cmd) {
+		config, err := configureApp()
 		if err != nil {
-			usage(fmt.Sprintf("Cannot read %s", *payload), 1)
+			usage(fmt.Sprintf("%s", err), 1)
+		}
+		api, err = newSession(config)
+		if err != nil {
+			usage(fmt.Sprintf("%s", err), 1)
 		}
-		cmd.Payload = string(src)
 	}
-
-	src, err := runCmd(cmd, config)
+	result, err := runCmd(api, cmd)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	fmt.Println(src)
+	fmt.Println(result)
 	os.Exit(0)
 }
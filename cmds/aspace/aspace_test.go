@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsNDJSON(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{"single record", `{"subject":"repository","action":"list"}`, false},
+		{"single record, multi-line pretty print", "{\n\"subject\":\"repository\"\n}", false},
+		{"two records", "{\"subject\":\"repository\"}\n{\"subject\":\"accession\"}", true},
+		{"two records with blank lines", "{\"subject\":\"repository\"}\n\n{\"subject\":\"accession\"}\n", true},
+		{"empty", "", false},
+		{"invalid json", "{\"subject\":\"repository\"}\nnot json", false},
+	}
+	for _, tc := range testCases {
+		if got := isNDJSON([]byte(tc.src)); got != tc.want {
+			t.Errorf("%s: isNDJSON() = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseCmd(t *testing.T) {
+	cmd, err := parseCmd([]string{"accession", "create", `{"title":"Test"}`, "--quiet"})
+	if err != nil {
+		t.Fatalf("parseCmd() failed, %s", err)
+	}
+	if cmd.Subject != "accession" {
+		t.Errorf("Subject = %q, want %q", cmd.Subject, "accession")
+	}
+	if cmd.Action != "create" {
+		t.Errorf("Action = %q, want %q", cmd.Action, "create")
+	}
+	if cmd.Payload != `{"title":"Test"}` {
+		t.Errorf("Payload = %q, want %q", cmd.Payload, `{"title":"Test"}`)
+	}
+	if len(cmd.Options) != 1 || cmd.Options[0] != "quiet" {
+		t.Errorf("Options = %v, want [quiet]", cmd.Options)
+	}
+
+	if _, err := parseCmd([]string{"bogus", "create"}); err == nil {
+		t.Error("parseCmd() with an unknown subject should fail")
+	}
+	if _, err := parseCmd([]string{"accession", "bogus"}); err == nil {
+		t.Error("parseCmd() with an unknown action should fail")
+	}
+	if _, err := parseCmd([]string{"accession"}); err == nil {
+		t.Error("parseCmd() with too few args should fail")
+	}
+}
+
+func TestRunBulkHandlesOversizedLines(t *testing.T) {
+	// A single NDJSON line well past bufio.Scanner's default 64KB token
+	// size must still be scanned and counted, not silently dropped.
+	longPayload := strings.Repeat("x", 200*1024)
+	record := `{"subject":"bogus","action":"create","payload":"` + longPayload + `"}`
+	src := []byte(record + "\n" + `{"subject":"bogus","action":"create"}` + "\n")
+
+	failCount := runBulk(nil, src)
+	if failCount != 2 {
+		t.Errorf("runBulk() failCount = %d, want 2 (both records reference an unimplemented subject/action)", failCount)
+	}
+}
+
+func TestOptionValue(t *testing.T) {
+	cmd, err := parseCmd([]string{"accession", "import", "--format=ead3", "--quiet"})
+	if err != nil {
+		t.Fatalf("parseCmd() failed, %s", err)
+	}
+	if got := cmd.optionValue("format"); got != "ead3" {
+		t.Errorf("optionValue(%q) = %q, want %q", "format", got, "ead3")
+	}
+	if got := cmd.optionValue("quiet"); got != "" {
+		t.Errorf("optionValue(%q) = %q, want %q", "quiet", got, "")
+	}
+	if got := cmd.optionValue("missing"); got != "" {
+		t.Errorf("optionValue(%q) = %q, want %q", "missing", got, "")
+	}
+}
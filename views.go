@@ -28,7 +28,6 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
 	"strings"
 )
 
@@ -215,93 +214,97 @@ func MakeDigitalObjectMap(dname string) (map[string]*DigitalObject, error) {
 
 }
 
+// MakeAccessionMap crawls the path for accession records and builds a map
+// of URI to the full Accession record. The parameter dname usually is set
+// to the value of $CAIT_DATASETS. Unlike MakeSubjectMap and
+// MakeDigitalObjectMap, it walks the tree recursively since accessions
+// are typically stored nested by year/month rather than as a flat directory.
+func MakeAccessionMap(dname string) (map[string]*Accession, error) {
+	accessions := make(map[string]*Accession)
+	err := filepath.Walk(dname, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			log.Printf("Can't read %s, %s", p, err)
+			return nil
+		}
+		jsonModel := new(struct {
+			JSONModel string `json:"jsonmodel_type"`
+		})
+		if err := json.Unmarshal(src, jsonModel); err != nil || jsonModel.JSONModel != "accession" {
+			return nil
+		}
+		accession := new(Accession)
+		if err := json.Unmarshal(src, accession); err != nil {
+			log.Printf("Can't parse accession %s, %s", p, err)
+			return nil
+		}
+		if accession.URI != "" {
+			accessions[accession.URI] = accession
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Can't read accessions from %s, %s", dname, err)
+	}
+	return accessions, nil
+}
+
 //
 // Browsing data
 //
 
-// MakeAccessionTitleIndex crawls the path for accession records and generates
-// a map of navigation links that can be used in search results or browsing views.
+// MakeAccessionTitleIndex crawls the path for accession records,
+// incrementally updating the on-disk BrowseIndex (see browseindex.go) for
+// any record whose mtime is newer than what's already stored, then
+// streams the full map of navigation links from that index. It is kept
+// as a thin wrapper around BrowseIndex for callers that still want the
+// whole map in memory at once; new code should prefer opening a
+// BrowseIndex directly and using Neighbors/Range/PrefixSearch so that
+// re-indexing after a partial harvest stays O(changed) rather than
+// O(all).
 // The parameter dname usually is set to the value of $CAIT_DATASETS
 // Output is a map of URI pointing at NavElementView for that URI.
 func MakeAccessionTitleIndex(dname string) (map[string]*NavElementView, error) {
-	// Title index keyed by URI
-	titleIndex := make(map[string]*NavElementView)
-	titlesWithURI := []string{}
+	idx, err := OpenBrowseIndex(filepath.Join(dname, BrowseIndexFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Close()
+
 	log.Printf("Making Accession Title Index")
-	filepath.Walk(dname, func(p string, info os.FileInfo, err error) error {
-		if strings.HasSuffix(p, ".json") {
-			src, err := ioutil.ReadFile(p)
-			if err != nil {
-				log.Printf("Can't read %s, %s", p, err)
-				return nil
-			}
-			accession := new(struct {
-				Title     string `json:"title,omitempty"`
-				URI       string `json:"uri"`
-				JSONModel string `json:"jsonmodel_type"`
-			})
-			err = json.Unmarshal(src, &accession)
-			if err != nil {
-				log.Printf("Can't unpack accession info %s, %s", p, err)
-			}
-			if accession.JSONModel == "accession" {
-				//FIXME: Store the info.
-				nav := new(NavElementView)
-				nav.ThisLabel = accession.Title
-				nav.ThisURI = accession.URI
-				titleIndex[accession.URI] = nav
-				titlesWithURI = append(titlesWithURI, fmt.Sprintf("%s|%s", accession.Title, accession.URI))
-			}
-			log.Printf("Recorded %s", p)
+	err = filepath.Walk(dname, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info == nil || info.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			log.Printf("Can't read %s, %s", p, err)
+			return nil
+		}
+		accession := new(struct {
+			Title     string `json:"title,omitempty"`
+			URI       string `json:"uri"`
+			JSONModel string `json:"jsonmodel_type"`
+		})
+		if err := json.Unmarshal(src, &accession); err != nil {
+			log.Printf("Can't unpack accession info %s, %s", p, err)
+			return nil
+		}
+		if accession.JSONModel != "accession" {
+			return nil
+		}
+		if err := idx.AddOrUpdate(accession.URI, accession.Title, info.ModTime()); err != nil {
+			log.Printf("Can't index %s, %s", p, err)
 		}
 		return nil
 	})
-
-	if len(titlesWithURI) == 0 {
-		return nil, fmt.Errorf("No titles found")
-	}
-	if len(titleIndex) == 0 {
-		return nil, fmt.Errorf("title index empty")
-	}
-
-	// make a uri extraction func
-	extractURI := func(s string) string {
-		pos := strings.LastIndex(s, "|")
-		pos++
-		return s[pos:]
-	}
-
-	// Sort the titles
-	log.Printf("Sorting %d titles", len(titlesWithURI))
-	sort.Strings(titlesWithURI)
-	// go through sorted titles and populate Next and Prev appropriately
-	log.Printf("Linked %d titles", len(titleIndex))
-	lastI := len(titlesWithURI) - 1
-	for i, val := range titlesWithURI {
-		uri := extractURI(val)
-		_, thisOk := titleIndex[uri]
-		if thisOk == true {
-			if i > 0 {
-				prevURI := extractURI(titlesWithURI[i-1])
-				prev, prevOK := titleIndex[prevURI]
-				if prevOK == true {
-					titleIndex[uri].PrevLabel = prev.ThisLabel
-					titleIndex[uri].PrevURI = prev.ThisURI
-				}
-			}
-
-			if i < lastI {
-				nextURI := extractURI(titlesWithURI[i+1])
-				next, nextOK := titleIndex[nextURI]
-				if nextOK == true {
-					titleIndex[uri].NextLabel = next.ThisLabel
-					titleIndex[uri].NextURI = next.ThisURI
-				}
-			}
-		}
-		log.Printf("%s, nav: %s\n", uri, titleIndex[uri])
+	if err != nil {
+		return nil, fmt.Errorf("Can't walk %s, %s", dname, err)
 	}
-	return titleIndex, nil
+	return idx.FullMap()
 }
 
 //